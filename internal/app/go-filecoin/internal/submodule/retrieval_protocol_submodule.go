@@ -0,0 +1,210 @@
+package submodule
+
+import (
+	"context"
+	"os"
+
+	"github.com/filecoin-project/go-address"
+	blockservice "github.com/ipfs/go-blockservice"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	merkledag "github.com/ipfs/go-merkledag"
+
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	retrievalimpl "github.com/filecoin-project/go-fil-markets/retrievalmarket/impl"
+	rmnet "github.com/filecoin-project/go-fil-markets/retrievalmarket/network"
+	"github.com/filecoin-project/specs-actors/actors/abi"
+	"github.com/filecoin-project/specs-actors/actors/abi/big"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipld/go-car"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pkg/errors"
+
+	retrievalmarketconnector "github.com/filecoin-project/go-filecoin/internal/app/go-filecoin/connectors/retrieval_market"
+	"github.com/filecoin-project/go-filecoin/internal/app/go-filecoin/plumbing/msg"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/cborutil"
+	"github.com/filecoin-project/go-filecoin/internal/pkg/piecemanager"
+	appstate "github.com/filecoin-project/go-filecoin/internal/pkg/state"
+)
+
+// RetrievalClientDSPrefix is a prefix for all datastore keys used by the retrieval client
+const RetrievalClientDSPrefix = "retrievals/client"
+
+// RetrievalProviderDSPrefix is a prefix for all datastore keys used by the retrieval provider
+const RetrievalProviderDSPrefix = "retrievals/provider"
+
+// QueryOffer is the result of querying a single retrieval miner for a payload, used to decide
+// whether and from whom to retrieve.
+type QueryOffer struct {
+	Err string
+
+	Root cid.Cid
+
+	Size                    uint64
+	MinPrice                abi.TokenAmount
+	PaymentInterval         uint64
+	PaymentIntervalIncrease uint64
+
+	Miner       address.Address
+	MinerPeerID peer.ID
+}
+
+// RetrievalProtocolSubmodule enhances the node with retrieval protocol
+// capabilities.
+type RetrievalProtocolSubmodule struct {
+	RetrievalClient   retrievalmarket.RetrievalClient
+	RetrievalProvider retrievalmarket.RetrievalProvider
+	bs                blockstore.Blockstore
+}
+
+// NewRetrievalProtocolSubmodule creates a new retrieval protocol submodule.
+func NewRetrievalProtocolSubmodule(
+	ctx context.Context,
+	c *ChainSubmodule,
+	mw *msg.Waiter,
+	h host.Host,
+	ds datastore.Batching,
+	bs blockstore.Blockstore,
+	stateViewer *appstate.Viewer,
+) (*RetrievalProtocolSubmodule, error) {
+	cnode := retrievalmarketconnector.NewRetrievalClientNodeConnector(cborutil.NewIpldStore(bs), c.State, mw, stateViewer)
+	clientDs := namespace.Wrap(ds, datastore.NewKey(RetrievalClientDSPrefix))
+	client, err := retrievalimpl.NewClient(rmnet.NewFromLibp2pHost(h), bs, cnode, clientDs)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating retrieval client")
+	}
+
+	sm := &RetrievalProtocolSubmodule{
+		RetrievalClient: client,
+		bs:              bs,
+	}
+	sm.RetrievalClient.SubscribeToEvents(cnode.EventLogger)
+	return sm, nil
+}
+
+// AddRetrievalProvider wires up a retrieval provider for the given miner actor. It should only
+// be called once mining has been enabled, mirroring AddStorageProvider.
+func (sm *RetrievalProtocolSubmodule) AddRetrievalProvider(
+	ctx context.Context,
+	minerAddr address.Address,
+	c *ChainSubmodule,
+	mw *msg.Waiter,
+	pm piecemanager.PieceManager,
+	h host.Host,
+	ds datastore.Batching,
+	bs blockstore.Blockstore,
+	ps piecestore.PieceStore,
+	stateViewer *appstate.Viewer,
+) error {
+	pnode := retrievalmarketconnector.NewRetrievalProviderNodeConnector(minerAddr, c.State, mw, pm, stateViewer)
+	providerDs := namespace.Wrap(ds, datastore.NewKey(RetrievalProviderDSPrefix))
+
+	provider, err := retrievalimpl.NewProvider(minerAddr, pnode, rmnet.NewFromLibp2pHost(h), ps, bs, providerDs)
+	if err != nil {
+		return err
+	}
+
+	sm.RetrievalProvider = provider
+	sm.RetrievalProvider.SubscribeToEvents(pnode.EventLogger)
+	return nil
+}
+
+// Client returns the retrieval client.
+func (sm *RetrievalProtocolSubmodule) Client() retrievalmarket.RetrievalClient {
+	return sm.RetrievalClient
+}
+
+// Provider returns the retrieval provider, if mining and retrieval serving have been enabled.
+func (sm *RetrievalProtocolSubmodule) Provider() (retrievalmarket.RetrievalProvider, error) {
+	if sm.RetrievalProvider == nil {
+		return nil, errors.New("Mining has not been started so retrieval provider is not available")
+	}
+	return sm.RetrievalProvider, nil
+}
+
+// ClientFindData queries known providers of payloadCID and returns an offer for each one that
+// responded successfully.
+func (sm *RetrievalProtocolSubmodule) ClientFindData(ctx context.Context, payloadCID cid.Cid) ([]QueryOffer, error) {
+	peers := sm.RetrievalClient.FindProviders(payloadCID)
+
+	offers := make([]QueryOffer, 0, len(peers))
+	for _, p := range peers {
+		resp, err := sm.RetrievalClient.Query(ctx, p, payloadCID, retrievalmarket.QueryParams{})
+		if err != nil {
+			continue
+		}
+
+		offers = append(offers, QueryOffer{
+			Root:                    payloadCID,
+			Size:                    resp.Size,
+			MinPrice:                resp.MinPricePerByte,
+			PaymentInterval:         resp.MaxPaymentInterval,
+			PaymentIntervalIncrease: resp.MaxPaymentIntervalIncrease,
+			Miner:                   p.Address,
+			MinerPeerID:             p.ID,
+		})
+	}
+	return offers, nil
+}
+
+// ClientRetrieve retrieves the payload described by offer from its provider, paying from
+// clientWallet, and writes the retrieved payload to outPath.
+func (sm *RetrievalProtocolSubmodule) ClientRetrieve(ctx context.Context, offer QueryOffer, clientWallet address.Address, outPath string) error {
+	if offer.Err != "" {
+		return errors.New(offer.Err)
+	}
+
+	params, err := retrievalmarket.NewParamsV0(offer.MinPrice, offer.PaymentInterval, offer.PaymentIntervalIncrease)
+	if err != nil {
+		return err
+	}
+
+	// MinPrice is a per-byte price; the funds committed to the deal must cover the whole
+	// transfer, not just a single byte of it.
+	totalFunds := big.Mul(offer.MinPrice, big.NewInt(int64(offer.Size)))
+
+	if _, err := sm.RetrievalClient.Retrieve(ctx, offer.Root, params, totalFunds, offer.MinerPeerID, clientWallet, offer.Miner); err != nil {
+		return err
+	}
+
+	return sm.exportPayload(ctx, offer.Root, outPath)
+}
+
+// exportPayload writes the DAG rooted at root, which by now lives in the shared blockstore, to
+// outPath as a CAR file.
+func (sm *RetrievalProtocolSubmodule) exportPayload(ctx context.Context, root cid.Cid, outPath string) error {
+	f, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	dagService := merkledag.NewDAGService(blockservice.New(sm.bs, offline.Exchange(sm.bs)))
+	return car.WriteCar(ctx, dagService, []cid.Cid{root}, f)
+}
+
+// RetrievalProviderSetPricePerByte sets the price the provider charges per byte served.
+func (sm *RetrievalProtocolSubmodule) RetrievalProviderSetPricePerByte(ctx context.Context, price abi.TokenAmount) error {
+	provider, err := sm.Provider()
+	if err != nil {
+		return err
+	}
+
+	provider.SetPricePerByte(price)
+	return nil
+}
+
+// RetrievalProviderSetPaymentInterval sets the provider's payment interval and increase.
+func (sm *RetrievalProtocolSubmodule) RetrievalProviderSetPaymentInterval(ctx context.Context, interval uint64, intervalIncrease uint64) error {
+	provider, err := sm.Provider()
+	if err != nil {
+		return err
+	}
+
+	provider.SetPaymentInterval(interval, intervalIncrease)
+	return nil
+}
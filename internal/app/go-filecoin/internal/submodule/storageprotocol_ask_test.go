@@ -0,0 +1,46 @@
+package submodule
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/storedask"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/testnodes"
+	"github.com/filecoin-project/specs-actors/actors/abi"
+	"github.com/ipfs/go-datastore"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStorageProviderAsk exercises StorageProviderGetAsk/SetAsk against a StoredAsk built the
+// same way AddStorageProvider builds one, so it doesn't need a running provider or chain state.
+func TestStorageProviderAsk(t *testing.T) {
+	minerAddr, err := address.NewIDAddress(101)
+	require.NoError(t, err)
+
+	ds := datastore.NewMapDatastore()
+	pnode := &testnodes.FakeProviderNode{}
+	storedAsk, err := storedask.NewStoredAsk(ds, datastore.NewKey(AskDSKey).ChildString(minerAddr.String()), pnode, minerAddr)
+	require.NoError(t, err)
+
+	sm := &StorageProtocolSubmodule{
+		storedAsks: map[address.Address]*storedask.StoredAsk{
+			minerAddr: storedAsk,
+		},
+	}
+
+	require.NoError(t, sm.StorageProviderSetAsk(context.Background(), minerAddr, abi.NewTokenAmount(1), abi.ChainEpoch(100)))
+
+	ask, err := sm.StorageProviderGetAsk(context.Background(), minerAddr)
+	require.NoError(t, err)
+	require.NotNil(t, ask)
+
+	other, err := address.NewIDAddress(102)
+	require.NoError(t, err)
+
+	_, err = sm.StorageProviderGetAsk(context.Background(), other)
+	require.Error(t, err)
+
+	err = sm.StorageProviderSetAsk(context.Background(), other, abi.NewTokenAmount(1), abi.ChainEpoch(100))
+	require.Error(t, err)
+}
@@ -0,0 +1,74 @@
+package submodule
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blockservice "github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipld/go-car"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestCAR writes a single-node CAR file to dir and returns its path and root CID.
+func writeTestCAR(t *testing.T, dir string) (string, cid.Cid) {
+	t.Helper()
+
+	srcBs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	nd := merkledag.NewRawNode([]byte("storage protocol CAR fixture"))
+	require.NoError(t, srcBs.Put(nd))
+
+	path := filepath.Join(dir, "fixture.car")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+
+	dagServ := merkledag.NewDAGService(blockservice.New(srcBs, offline.Exchange(srcBs)))
+	require.NoError(t, car.WriteCar(context.Background(), dagServ, []cid.Cid{nd.Cid()}, f))
+
+	return path, nd.Cid()
+}
+
+// TestClientImportCAR is a local-IO round trip: no chain state is involved, so it only needs a
+// small CAR fixture and a fresh blockstore to import into.
+func TestClientImportCAR(t *testing.T) {
+	dir := t.TempDir()
+	path, root := writeTestCAR(t, dir)
+
+	sm := &StorageProtocolSubmodule{
+		bs: blockstore.NewBlockstore(datastore.NewMapDatastore()),
+	}
+
+	got, err := sm.ClientImportCAR(context.Background(), path)
+	require.NoError(t, err)
+	require.Equal(t, root, got)
+
+	_, err = sm.ClientImportCAR(context.Background(), filepath.Join(dir, "does-not-exist.car"))
+	require.Error(t, err)
+}
+
+// TestClientGenerateCommP checks that commP generation is deterministic for identical input and
+// produces a defined piece CID/size, without needing any chain state.
+func TestClientGenerateCommP(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "payload.dat")
+	require.NoError(t, os.WriteFile(path, []byte("some storage deal payload bytes"), 0644))
+
+	sm := &StorageProtocolSubmodule{}
+
+	pieceCID, pieceSize, err := sm.ClientGenerateCommP(context.Background(), path)
+	require.NoError(t, err)
+	require.NotEqual(t, cid.Undef, pieceCID)
+	require.True(t, pieceSize > 0)
+
+	pieceCID2, pieceSize2, err := sm.ClientGenerateCommP(context.Background(), path)
+	require.NoError(t, err)
+	require.Equal(t, pieceCID, pieceCID2)
+	require.Equal(t, pieceSize, pieceSize2)
+}
@@ -0,0 +1,71 @@
+package submodule
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+	iface "github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/specs-actors/actors/abi"
+	"github.com/ipfs/go-datastore"
+	"github.com/stretchr/testify/require"
+)
+
+// TestClientDealInfoRoundTrip exercises ClientGetDealInfo/ClientListDeals over a fake
+// datastore.Batching, independent of recordClientDealTransition, so it doesn't need a real
+// storage client to produce deal updates.
+func TestClientDealInfoRoundTrip(t *testing.T) {
+	sm := &StorageProtocolSubmodule{
+		dealTracking: datastore.NewMapDatastore(),
+	}
+
+	minerAddr, err := address.NewIDAddress(101)
+	require.NoError(t, err)
+
+	cids := shared_testutil.GenerateCids(2)
+	infos := []DealInfo{
+		{
+			ProposalCid:   cids[0],
+			PieceCID:      cids[0],
+			State:         iface.StorageDealActive,
+			Provider:      minerAddr,
+			Size:          1024,
+			PricePerEpoch: abi.NewTokenAmount(1),
+			Duration:      99,
+			DealID:        7,
+		},
+		{
+			ProposalCid:   cids[1],
+			PieceCID:      cids[1],
+			State:         iface.StorageDealSealing,
+			Provider:      minerAddr,
+			Size:          2048,
+			PricePerEpoch: abi.NewTokenAmount(2),
+			Duration:      50,
+			DealID:        8,
+		},
+	}
+
+	for _, info := range infos {
+		raw, err := json.Marshal(&info)
+		require.NoError(t, err)
+		require.NoError(t, sm.dealTracking.Put(datastore.NewKey(info.ProposalCid.String()), raw))
+	}
+
+	got, err := sm.ClientGetDealInfo(context.Background(), infos[0].ProposalCid)
+	require.NoError(t, err)
+	require.Equal(t, infos[0], *got)
+
+	all, err := sm.ClientListDeals(context.Background())
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+
+	_, err = sm.ClientGetDealInfo(context.Background(), cids[1])
+	require.NoError(t, err)
+
+	unknown := shared_testutil.GenerateCids(1)[0]
+	_, err = sm.ClientGetDealInfo(context.Background(), unknown)
+	require.Error(t, err)
+}
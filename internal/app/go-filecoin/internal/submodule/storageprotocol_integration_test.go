@@ -0,0 +1,125 @@
+package submodule
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-statestore"
+	"github.com/filecoin-project/go-storedcounter"
+
+	graphsyncimpl "github.com/filecoin-project/go-data-transfer/impl/graphsync"
+	"github.com/filecoin-project/go-fil-markets/filestore"
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/discovery"
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+	iface "github.com/filecoin-project/go-fil-markets/storagemarket"
+	impl "github.com/filecoin-project/go-fil-markets/storagemarket/impl"
+	smvalid "github.com/filecoin-project/go-fil-markets/storagemarket/impl/requestvalidation"
+	smnetwork "github.com/filecoin-project/go-fil-markets/storagemarket/network"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/testnodes"
+	"github.com/filecoin-project/specs-actors/actors/abi"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-graphsync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipfs/go-merkledag"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStorageProtocolSubmoduleIntegration drives a full storage deal, client to provider, over a
+// libp2p mocknet and a real graphsync data-transfer stack. The client and provider are built by
+// newTestStorageClient and newTestStorageProvider below, which repeat the data-transfer,
+// voucher-validator and datastore wiring of NewStorageProtocolSubmodule and AddStorageProvider;
+// those two functions themselves are not called. newTestStorageProvider keeps a single,
+// un-namespaced provider store and validates pushes against that same store. It uses
+// go-fil-markets' own fake chain nodes, so no chain/message logic is involved, which is covered
+// by the storagemarketconnector tests.
+func TestStorageProtocolSubmoduleIntegration(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	td := shared_testutil.NewLibp2pTestData(ctx, t)
+
+	minerAddr, err := address.NewIDAddress(101)
+	require.NoError(t, err)
+
+	client, _ := newTestStorageClient(t, td.Host1, td.Ds1, td.Bs1, td.GraphSync1)
+	provider, _ := newTestStorageProvider(t, td.Host2, minerAddr, td.Ds2, td.Bs2, td.GraphSync2)
+
+	require.NoError(t, provider.Start(ctx))
+	defer func() { _ = provider.Stop() }()
+
+	dealUpdates := make(chan iface.ClientDeal, 16)
+	client.SubscribeToEvents(func(_ iface.ClientEvent, deal iface.ClientDeal) {
+		dealUpdates <- deal
+	})
+
+	payload := merkledag.NewRawNode([]byte("storage protocol integration payload"))
+	require.NoError(t, td.Bs1.Put(payload))
+	payloadCID := payload.Cid()
+	providerInfo := iface.StorageProviderInfo{
+		Address: minerAddr,
+		Peer:    td.Host2.ID(),
+	}
+
+	result, err := client.ProposeStorageDeal(
+		ctx,
+		minerAddr,
+		&providerInfo,
+		&iface.DataRef{TransferType: iface.TTGraphsync, Root: payloadCID},
+		abi.ChainEpoch(1),
+		abi.ChainEpoch(100),
+		abi.NewTokenAmount(1),
+		abi.NewTokenAmount(0),
+		abi.RegisteredProof_StackedDRG2KiBSeal,
+	)
+	require.NoError(t, err)
+
+	for {
+		select {
+		case deal := <-dealUpdates:
+			if deal.ProposalCid.Equals(result.ProposalCid) && deal.State == iface.StorageDealActive {
+				return
+			}
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for deal to become active")
+		}
+	}
+}
+
+func newTestStorageClient(t *testing.T, h host.Host, ds datastore.Batching, bs blockstore.Blockstore, gsync graphsync.GraphExchange) (iface.StorageClient, *testnodes.FakeClientNode) {
+	dtStoredCounter := storedcounter.New(ds, datastore.NewKey(DTCounterDSKey))
+	dt := graphsyncimpl.NewGraphSyncDataTransfer(h, gsync, dtStoredCounter)
+
+	clientDs := namespace.Wrap(ds, datastore.NewKey(ClientDSPrefix))
+	validator := smvalid.NewUnifiedRequestValidator(nil, statestore.New(clientDs))
+	require.NoError(t, dt.RegisterVoucherType(&smvalid.StorageDataTransferVoucher{}, validator))
+
+	local := discovery.NewLocal(namespace.Wrap(ds, datastore.NewKey(DiscoveryDSPrefix)))
+	cnode := &testnodes.FakeClientNode{}
+
+	client, err := impl.NewClient(smnetwork.NewFromLibp2pHost(h), bs, dt, local, clientDs, cnode)
+	require.NoError(t, err)
+	return client, cnode
+}
+
+func newTestStorageProvider(t *testing.T, h host.Host, minerAddr address.Address, ds datastore.Batching, bs blockstore.Blockstore, gsync graphsync.GraphExchange) (iface.StorageProvider, *testnodes.FakeProviderNode) {
+	dtStoredCounter := storedcounter.New(ds, datastore.NewKey(DTCounterDSKey))
+	dt := graphsyncimpl.NewGraphSyncDataTransfer(h, gsync, dtStoredCounter)
+
+	providerDs := namespace.Wrap(ds, datastore.NewKey(ProviderDSPrefix))
+	validator := smvalid.NewUnifiedRequestValidator(statestore.New(providerDs), nil)
+	require.NoError(t, dt.RegisterVoucherType(&smvalid.StorageDataTransferVoucher{}, validator))
+
+	fs, err := filestore.NewLocalFileStore(filestore.OsPath(t.TempDir()))
+	require.NoError(t, err)
+	ps := piecestore.NewPieceStore(namespace.Wrap(ds, datastore.NewKey(PieceStoreDSPrefix)))
+	pnode := &testnodes.FakeProviderNode{}
+
+	provider, err := impl.NewProvider(smnetwork.NewFromLibp2pHost(h), providerDs, bs, fs, ps, dt, pnode, minerAddr, abi.RegisteredProof_StackedDRG2KiBSeal, nil)
+	require.NoError(t, err)
+	return provider, pnode
+}